@@ -1,19 +1,28 @@
+// Package ad is a thin preset over providers/rfc2136 for Active Directory:
+// it keeps the AD_* environment variables existing deployments already set,
+// and uses GSS-TSIG (what a default-configured AD DNS server requires)
+// whenever a Kerberos keytab is configured, before delegating everything
+// else to the RFC 2136 implementation. Without a keytab it signs with a
+// static TSIG key if AD_TSIG_KEYNAME is set, and sends unsigned updates
+// otherwise.
 package ad
 
 import (
 	"fmt"
 	"net"
 	"os"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/miekg/dns"
 	"github.com/rancher/external-dns/providers"
-	"github.com/rancher/external-dns/utils"
+	"github.com/rancher/external-dns/providers/rfc2136"
 )
 
+// adProvider embeds *rfc2136.Provider so AddRecord/RemoveRecord/
+// UpdateRecord/GetRecords/HealthCheck are all the RFC 2136 implementation;
+// only Init and GetName are AD-specific.
 type adProvider struct {
-	nameserver string
-	zoneName   string
+	*rfc2136.Provider
 }
 
 func init() {
@@ -30,165 +39,59 @@ func (r *adProvider) Init(rootDomainName string) error {
 		port = "53"
 	}
 
-	r.nameserver = net.JoinHostPort(host, port)
-	r.zoneName = dns.Fqdn(rootDomainName)
+	nameserver := net.JoinHostPort(host, port)
+	r.Provider = rfc2136.NewProvider(nameserver, rootDomainName)
 
-	logrus.Infof("Configured %s with zone '%s' and nameserver '%s'",
-		r.GetName(), r.zoneName, r.nameserver)
-
-	return nil
-}
-
-func (*adProvider) GetName() string {
-	return "AD"
-}
-
-func (r *adProvider) HealthCheck() error {
-	_, err := r.GetRecords()
-	return err
-}
-
-func (r *adProvider) AddRecord(record utils.DnsRecord) error {
-	logrus.Debugf("Adding RRset '%s %s'", record.Fqdn, record.Type)
-	m := new(dns.Msg)
-	m.SetUpdate(r.zoneName)
-	var rrs []dns.RR
-	for _, rec := range record.Records {
-		logrus.Debugf("Adding RR: '%s %d %s %s'", record.Fqdn, record.TTL, record.Type, rec)
-		rr, err := dns.NewRR(fmt.Sprintf("%s %d %s %s", record.Fqdn, record.TTL, record.Type, rec))
-		if err != nil {
-			return fmt.Errorf("Failed to build RR: %v", err)
-		}
-		rrs = append(rrs, rr)
+	if raw := os.Getenv("AD_ZONES"); len(raw) > 0 {
+		r.SetZones(strings.Split(raw, ","))
 	}
 
-	m.Insert(rrs)
-	err := r.sendMessage(m)
-	if err != nil {
-		return fmt.Errorf("AD query failed: %v", err)
-	}
-
-	return nil
-}
+	r.SetSafeAdd(os.Getenv("AD_SAFE_ADD") == "true")
 
-func (r *adProvider) RemoveRecord(record utils.DnsRecord) error {
-	logrus.Debugf("Removing RRset '%s %s'", record.Fqdn, record.Type)
-	m := new(dns.Msg)
-	m.SetUpdate(r.zoneName)
-	rr, err := dns.NewRR(fmt.Sprintf("%s 0 %s 0.0.0.0", record.Fqdn, record.Type))
-	if err != nil {
-		return fmt.Errorf("Could not construct RR: %v", err)
+	if err := r.initAuth(); err != nil {
+		return err
 	}
 
-	rrs := make([]dns.RR, 1)
-	rrs[0] = rr
-	m.RemoveRRset(rrs)
-	err = r.sendMessage(m)
-	if err != nil {
-		return fmt.Errorf("AD query failed: %v", err)
-	}
+	logrus.Infof("Configured %s with zone '%s' and nameserver '%s'",
+		r.GetName(), rootDomainName, nameserver)
 
 	return nil
 }
 
-func (r *adProvider) UpdateRecord(record utils.DnsRecord) error {
-	err := r.RemoveRecord(record)
-	if err != nil {
-		return err
-	}
-
-	return r.AddRecord(record)
-}
-
-func (r *adProvider) GetRecords() ([]utils.DnsRecord, error) {
-	var records []utils.DnsRecord
-	list, err := r.list()
-	if err != nil {
-		return records, err
-	}
-
-OuterLoop:
-	for _, rr := range list {
-		if rr.Header().Class != dns.ClassINET {
-			continue
+// initAuth configures either static TSIG or GSS-TSIG authentication for
+// outgoing updates and transfers, based on which env vars are set. GSS-TSIG
+// (the default for Active Directory) takes precedence when a keytab is
+// configured.
+func (r *adProvider) initAuth() error {
+	keytab := os.Getenv("AD_KRB5_KEYTAB")
+	principal := os.Getenv("AD_KRB5_PRINCIPAL")
+	realm := os.Getenv("AD_KRB5_REALM")
+
+	if len(keytab) > 0 {
+		if len(principal) == 0 || len(realm) == 0 {
+			return fmt.Errorf("AD_KRB5_PRINCIPAL and AD_KRB5_REALM are required when AD_KRB5_KEYTAB is set")
 		}
 
-		rrFqdn := rr.Header().Name
-		rrTTL := int(rr.Header().Ttl)
-		var rrType string
-		var rrValues []string
-		switch rr.Header().Rrtype {
-		case dns.TypeCNAME:
-			rrValues = []string{rr.(*dns.CNAME).Target}
-			rrType = "CNAME"
-		case dns.TypeA:
-			rrValues = []string{rr.(*dns.A).A.String()}
-			rrType = "A"
-		case dns.TypeAAAA:
-			rrValues = []string{rr.(*dns.AAAA).AAAA.String()}
-			rrType = "AAAA"
-		case dns.TypeTXT:
-			rrValues = rr.(*dns.TXT).Txt
-			rrType = "TXT"
-		default:
-			continue // Unhandled record type
-		}
+		r.SetGSSTSIG(keytab, principal, realm)
+		return nil
+	}
 
-		for idx, existingRecord := range records {
-			if existingRecord.Fqdn == rrFqdn && existingRecord.Type == rrType {
-				records[idx].Records = append(records[idx].Records, rrValues...)
-				continue OuterLoop
-			}
+	if keyname := os.Getenv("AD_TSIG_KEYNAME"); len(keyname) > 0 {
+		secret := os.Getenv("AD_TSIG_SECRET")
+		if len(secret) == 0 {
+			return fmt.Errorf("AD_TSIG_SECRET is not set")
 		}
 
-		record := utils.DnsRecord{
-			Fqdn:    rrFqdn,
-			Type:    rrType,
-			TTL:     rrTTL,
-			Records: rrValues,
+		algo := os.Getenv("AD_TSIG_ALGO")
+		if len(algo) == 0 {
+			algo = "hmac-sha256."
 		}
-
-		records = append(records, record)
-	}
-
-	return records, nil
-}
-
-func (r *adProvider) sendMessage(msg *dns.Msg) error {
-	c := new(dns.Client)
-	c.SingleInflight = true
-	resp, _, err := c.Exchange(msg, r.nameserver)
-	if err != nil {
-		return err
-	}
-
-	if resp != nil && resp.Rcode != dns.RcodeSuccess {
-		return fmt.Errorf("Bad return code: %s", dns.RcodeToString[resp.Rcode])
+		r.SetTsig(keyname, algo, secret)
 	}
 
 	return nil
 }
 
-func (r *adProvider) list() ([]dns.RR, error) {
-	logrus.Debugf("Fetching records for '%s'", r.zoneName)
-	t := new(dns.Transfer)
-
-	m := new(dns.Msg)
-	m.SetAxfr(r.zoneName)
-
-	env, err := t.In(m, r.nameserver)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to fetch records via AXFR: %v", err)
-	}
-
-	var records []dns.RR
-	for e := range env {
-		if e.Error != nil {
-			logrus.Errorf("AXFR envelope error: %v", e.Error)
-			continue
-		}
-		records = append(records, e.RR...)
-	}
-
-	return records, nil
-}
\ No newline at end of file
+func (*adProvider) GetName() string {
+	return "AD"
+}
@@ -0,0 +1,221 @@
+package rfc2136
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/miekg/dns"
+)
+
+// zoneCacheEntry holds the last materialized view of a zone, keyed by the
+// SOA serial it was built from, so GetRecords can skip the transfer
+// entirely when the zone hasn't changed.
+type zoneCacheEntry struct {
+	serial uint32
+	mname  string
+	email  string
+	rrs    []dns.RR
+}
+
+// recordCache caches one zoneCacheEntry per zone, avoiding a full AXFR on
+// every reconcile for zones that haven't changed.
+type recordCache struct {
+	mu      sync.Mutex
+	entries map[string]*zoneCacheEntry
+}
+
+func newRecordCache() *recordCache {
+	return &recordCache{entries: make(map[string]*zoneCacheEntry)}
+}
+
+// invalidate drops the cached entry for zone, forcing a fresh transfer on
+// the next read. Called after a successful write to that zone.
+func (c *recordCache) invalidate(zone string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, zone)
+}
+
+func (c *recordCache) get(zone string) (*zoneCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[zone]
+	return entry, ok
+}
+
+func (c *recordCache) put(zone string, entry *zoneCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[zone] = entry
+}
+
+// recordsForZone returns the current RRs for zone, transferring only what
+// changed since the last call: an unchanged SOA serial returns the cached
+// RRs, a changed serial is fetched via IXFR (falling back to a full AXFR if
+// the server downgrades the transfer or this is the first request for the
+// zone).
+func (p *Provider) recordsForZone(zone string) ([]dns.RR, error) {
+	soa, err := p.soaRecord(zone)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch SOA for '%s': %v", zone, err)
+	}
+
+	if entry, ok := p.cache.get(zone); ok && entry.serial == soa.Serial {
+		return entry.rrs, nil
+	}
+
+	rrs, err := p.ixfr(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	p.cache.put(zone, &zoneCacheEntry{
+		serial: soa.Serial,
+		mname:  soa.Ns,
+		email:  soa.Mbox,
+		rrs:    rrs,
+	})
+
+	return rrs, nil
+}
+
+// ixfr fetches the RRs for zone incrementally when a prior serial is known,
+// applying the add/remove deltas to the cached RRs, and falls back to a
+// full AXFR when there is no cached entry or the server doesn't support (or
+// downgrades) the incremental transfer.
+func (p *Provider) ixfr(zone string) ([]dns.RR, error) {
+	entry, ok := p.cache.get(zone)
+	if !ok {
+		return p.list(zone)
+	}
+
+	m := new(dns.Msg)
+	m.SetIxfr(zone, entry.serial, entry.mname, entry.email)
+	if err := p.signMessage(&dns.Client{}, m); err != nil {
+		return nil, err
+	}
+
+	t := &dns.Transfer{DialTimeout: p.timeout, ReadTimeout: p.timeout}
+	t.TsigSecret = p.tsigSecret
+
+	env, err := t.In(m, p.nameserver)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch records via IXFR: %v", err)
+	}
+
+	var transferred []dns.RR
+	for e := range env {
+		if e.Error != nil {
+			logrus.Errorf("IXFR envelope error: %v", e.Error)
+			continue
+		}
+		transferred = append(transferred, e.RR...)
+	}
+
+	if len(transferred) < 2 {
+		// A lone SOA carries no zone data, so fetch the whole zone instead.
+		logrus.Debugf("Server returned a single SOA for '%s' IXFR, falling back to AXFR", zone)
+		return p.list(zone)
+	}
+
+	adds, removes, full := parseIxfr(transferred)
+	if full {
+		logrus.Debugf("Server returned a full transfer for '%s' IXFR, using it as-is", zone)
+		return transferred, nil
+	}
+
+	return applyIxfrDelta(entry.rrs, removes, adds), nil
+}
+
+// parseIxfr splits the RRs of an IXFR response into the RRs to add and
+// remove, per the envelope format of RFC 1995: SOA(new), then one or more
+// version sequences, each of the form SOA(old) removals... SOA(new)
+// additions.... A response can carry several such sequences when it spans
+// more than one update since the cached serial, so sections are classified
+// by their position in that old/new alternation, not by comparing a
+// section's bounding serial to the final serial in the response (a section
+// for an intermediate version has a "new" serial that isn't the final one
+// either). If the response doesn't follow that shape (the server
+// downgraded to a full AXFR), full is true and the caller should use the
+// RRs as the complete zone. A response of fewer than two RRs also reports
+// full, although a lone SOA holds no zone data; ixfr does an AXFR then.
+func parseIxfr(rrs []dns.RR) (adds, removes []dns.RR, full bool) {
+	if len(rrs) < 2 {
+		return nil, nil, true
+	}
+
+	if _, ok := rrs[0].(*dns.SOA); !ok {
+		return nil, nil, true
+	}
+	if _, ok := rrs[1].(*dns.SOA); !ok {
+		// Second record isn't a bounding SOA, so this is an AXFR-style
+		// full transfer rather than an incremental diff.
+		return nil, nil, true
+	}
+
+	i := 1
+	isRemoveSection := true
+	for i < len(rrs) {
+		if _, ok := rrs[i].(*dns.SOA); !ok {
+			return nil, nil, true
+		}
+		i++
+
+		var block []dns.RR
+		for i < len(rrs) {
+			if _, ok := rrs[i].(*dns.SOA); ok {
+				break
+			}
+			block = append(block, rrs[i])
+			i++
+		}
+
+		if isRemoveSection {
+			removes = append(removes, block...)
+		} else {
+			adds = append(adds, block...)
+		}
+		isRemoveSection = !isRemoveSection
+	}
+
+	return adds, removes, false
+}
+
+// applyIxfrDelta returns base with removes taken out (matched by exact RR
+// text) and adds appended.
+func applyIxfrDelta(base, removes, adds []dns.RR) []dns.RR {
+	removeSet := make(map[string]bool, len(removes))
+	for _, rr := range removes {
+		removeSet[rr.String()] = true
+	}
+
+	result := make([]dns.RR, 0, len(base)+len(adds))
+	for _, rr := range base {
+		if !removeSet[rr.String()] {
+			result = append(result, rr)
+		}
+	}
+
+	return append(result, adds...)
+}
+
+// soaRecord queries zone's SOA record directly, the cheap query used to
+// decide whether a zone has changed without transferring it.
+func (p *Provider) soaRecord(zone string) (*dns.SOA, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(zone, dns.TypeSOA)
+
+	resp, _, err := p.client().Exchange(m, p.nameserver)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rr := range resp.Answer {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no SOA record returned for '%s'", zone)
+}
@@ -0,0 +1,90 @@
+package rfc2136
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func mustRR(t *testing.T, s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("failed to parse RR %q: %v", s, err)
+	}
+	return rr
+}
+
+func soaWithSerial(t *testing.T, serial uint32) dns.RR {
+	rr := mustRR(t, "example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 3600 600 86400 60")
+	rr.(*dns.SOA).Serial = serial
+	return rr
+}
+
+// TestParseIxfrMultiVersion reproduces an IXFR response spanning two
+// versions (serials 10 -> 11 -> 12), which is exactly the case IXFR exists
+// to optimize when a reconcile misses more than one update. Classifying
+// sections by comparing their bounding SOA serial against the final serial
+// misclassifies the intermediate version's additions as removals.
+func TestParseIxfrMultiVersion(t *testing.T) {
+	del10 := mustRR(t, "old10.example.com. 300 IN A 10.0.0.1")
+	add11 := mustRR(t, "new11.example.com. 300 IN A 10.0.0.2")
+	del11 := mustRR(t, "old11.example.com. 300 IN A 10.0.0.3")
+	add12 := mustRR(t, "new12.example.com. 300 IN A 10.0.0.4")
+
+	rrs := []dns.RR{
+		soaWithSerial(t, 12),
+		soaWithSerial(t, 10), del10,
+		soaWithSerial(t, 11), add11,
+		soaWithSerial(t, 11), del11,
+		soaWithSerial(t, 12), add12,
+	}
+
+	adds, removes, full := parseIxfr(rrs)
+	if full {
+		t.Fatal("expected an incremental diff, got full=true")
+	}
+
+	wantAdds := []dns.RR{add11, add12}
+	wantRemoves := []dns.RR{del10, del11}
+
+	if !reflect.DeepEqual(adds, wantAdds) {
+		t.Fatalf("adds = %v, want %v", adds, wantAdds)
+	}
+	if !reflect.DeepEqual(removes, wantRemoves) {
+		t.Fatalf("removes = %v, want %v", removes, wantRemoves)
+	}
+}
+
+func TestParseIxfrFullTransferFallback(t *testing.T) {
+	rrs := []dns.RR{
+		soaWithSerial(t, 12),
+		mustRR(t, "www.example.com. 300 IN A 10.0.0.1"),
+		soaWithSerial(t, 12),
+	}
+
+	_, _, full := parseIxfr(rrs)
+	if !full {
+		t.Fatal("expected an AXFR-style response to be reported as a full transfer")
+	}
+}
+
+func TestApplyIxfrDelta(t *testing.T) {
+	base := []dns.RR{
+		mustRR(t, "a.example.com. 300 IN A 10.0.0.1"),
+		mustRR(t, "b.example.com. 300 IN A 10.0.0.2"),
+	}
+	removes := []dns.RR{mustRR(t, "a.example.com. 300 IN A 10.0.0.1")}
+	adds := []dns.RR{mustRR(t, "c.example.com. 300 IN A 10.0.0.3")}
+
+	got := applyIxfrDelta(base, removes, adds)
+
+	var names []string
+	for _, rr := range got {
+		names = append(names, rr.Header().Name)
+	}
+	want := []string{"b.example.com.", "c.example.com."}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("names = %v, want %v", names, want)
+	}
+}
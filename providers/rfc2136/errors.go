@@ -0,0 +1,45 @@
+package rfc2136
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// UpdateError indicates an RFC 2136 UPDATE was rejected by the server,
+// rather than failing to reach it. Callers can use IsPrerequisiteFailed to
+// distinguish a failed prerequisite from a transport-level failure.
+type UpdateError struct {
+	Rcode int
+}
+
+func (e *UpdateError) Error() string {
+	return fmt.Sprintf("update rejected: %s", dns.RcodeToString[e.Rcode])
+}
+
+// IsPrerequisiteFailed reports whether err is an UpdateError caused by a
+// failed RFC 2136 prerequisite, as opposed to some other server rejection.
+// This covers NXRRSET and YXDOMAIN (RFC 2136 section 2.4), as well as
+// NXDOMAIN (section 2.4.3), which is what a NameUsed prerequisite fails
+// with when the name has been concurrently deleted.
+func IsPrerequisiteFailed(err error) bool {
+	ue, ok := err.(*UpdateError)
+	if !ok {
+		return false
+	}
+	switch ue.Rcode {
+	case dns.RcodeNXRrset, dns.RcodeYXDomain, dns.RcodeNameError:
+		return true
+	default:
+		return false
+	}
+}
+
+// wrapQueryErr adds context to a transport-level failure, but passes a
+// typed *UpdateError through unwrapped so callers can still distinguish it.
+func wrapQueryErr(err error) error {
+	if _, ok := err.(*UpdateError); ok {
+		return err
+	}
+	return fmt.Errorf("RFC2136 query failed: %v", err)
+}
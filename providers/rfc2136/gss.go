@@ -0,0 +1,170 @@
+package rfc2136
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/miekg/dns"
+)
+
+// tkeyModeGSSAPI is TKEY mode 3, "GSS-API negotiation", per RFC 2930 section
+// 2. miekg/dns doesn't export a constant for it, so Mode is set directly.
+const tkeyModeGSSAPI = 3
+
+// gssContext is a cached GSS-TSIG security context negotiated with a single
+// AD DNS server. Contexts are expensive to establish (a full TKEY exchange)
+// so they are kept around until they expire or the server rejects them.
+type gssContext struct {
+	keyname string
+	secret  string
+	expiry  time.Time
+}
+
+func (c *gssContext) expired() bool {
+	return time.Now().After(c.expiry)
+}
+
+// gssNegotiator establishes and caches GSS-TSIG contexts via Kerberos TKEY
+// exchanges, as required to send secure dynamic updates to Active Directory.
+type gssNegotiator struct {
+	keytab    string
+	principal string
+	realm     string
+
+	mu       sync.Mutex
+	contexts map[string]*gssContext // nameserver -> context
+}
+
+func newGssNegotiator(keytabPath, principal, realm string) *gssNegotiator {
+	return &gssNegotiator{
+		keytab:    keytabPath,
+		principal: principal,
+		realm:     realm,
+		contexts:  make(map[string]*gssContext),
+	}
+}
+
+// context returns a still-valid GSS-TSIG context for nameserver, negotiating
+// (or renegotiating) one over TCP if none is cached or the cached one has
+// expired.
+func (g *gssNegotiator) context(nameserver string) (*gssContext, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if ctx, ok := g.contexts[nameserver]; ok && !ctx.expired() {
+		return ctx, nil
+	}
+
+	ctx, err := g.negotiate(nameserver)
+	if err != nil {
+		return nil, err
+	}
+
+	g.contexts[nameserver] = ctx
+	return ctx, nil
+}
+
+// invalidate drops the cached context for nameserver, forcing renegotiation
+// on the next call. Used when the server returns BADKEY/BADSIG.
+func (g *gssNegotiator) invalidate(nameserver string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.contexts, nameserver)
+}
+
+// negotiate performs the TKEY exchange (RFC 2930/3645) over TCP to establish
+// a GSS-TSIG security context with the AD DNS server at nameserver.
+func (g *gssNegotiator) negotiate(nameserver string) (*gssContext, error) {
+	kt, err := keytab.Load(g.keytab)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load keytab %s: %v", g.keytab, err)
+	}
+
+	// Prefer a system krb5.conf, which names the KDCs for g.realm. If none
+	// is installed, fall back to DNS SRV-based KDC/realm discovery, which is
+	// how a default-configured AD domain controller would be found anyway.
+	cfg, err := config.Load("/etc/krb5.conf")
+	if err != nil {
+		cfg = config.New()
+		cfg.LibDefaults.DNSLookupKDC = true
+		cfg.LibDefaults.DNSLookupRealm = true
+	}
+	cfg.LibDefaults.DefaultRealm = g.realm
+
+	cl := client.NewWithKeytab(g.principal, g.realm, kt, cfg)
+	if err := cl.Login(); err != nil {
+		return nil, fmt.Errorf("krb5 login failed for %s: %v", g.principal, err)
+	}
+	defer cl.Destroy()
+
+	spn := "DNS/" + nameserver
+	tkt, sessionKey, err := cl.GetServiceTicket(spn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service ticket for %s: %v", spn, err)
+	}
+
+	mechToken, err := spnego.NewKRB5TokenAPREQ(cl, tkt, sessionKey,
+		[]int{gssapi.ContextFlagInteg, gssapi.ContextFlagConf}, []int{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build GSSAPI AP-REQ: %v", err)
+	}
+	token, err := mechToken.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal GSSAPI AP-REQ: %v", err)
+	}
+
+	keyname := dns.Fqdn(fmt.Sprintf("%d.%s", time.Now().Unix(), g.realm))
+	tkey := &dns.TKEY{
+		Hdr: dns.RR_Header{
+			Name:   keyname,
+			Rrtype: dns.TypeTKEY,
+			Class:  dns.ClassANY,
+		},
+		Algorithm:  "gss-tsig.",
+		Mode:       tkeyModeGSSAPI,
+		KeySize:    uint16(len(token)),
+		Key:        fmt.Sprintf("%X", token),
+		Inception:  uint32(time.Now().Unix()),
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(keyname, dns.TypeTKEY)
+	m.Extra = append(m.Extra, tkey)
+
+	c := &dns.Client{Net: "tcp"}
+	resp, _, err := c.Exchange(m, nameserver)
+	if err != nil {
+		return nil, fmt.Errorf("TKEY exchange with %s failed: %v", nameserver, err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("TKEY exchange with %s rejected: %s", nameserver, dns.RcodeToString[resp.Rcode])
+	}
+
+	var negotiated *dns.TKEY
+	for _, rr := range resp.Extra {
+		if tk, ok := rr.(*dns.TKEY); ok {
+			negotiated = tk
+			break
+		}
+	}
+	if negotiated == nil {
+		return nil, fmt.Errorf("TKEY exchange with %s returned no TKEY record", nameserver)
+	}
+
+	logrus.Debugf("Negotiated GSS-TSIG context '%s' with %s, expires %s",
+		negotiated.Hdr.Name, nameserver, time.Unix(int64(negotiated.Expiration), 0))
+
+	return &gssContext{
+		keyname: negotiated.Hdr.Name,
+		secret:  negotiated.Key,
+		expiry:  time.Unix(int64(negotiated.Expiration), 0),
+	}, nil
+}
@@ -0,0 +1,452 @@
+// Package rfc2136 implements a DNS provider speaking plain RFC 2136 dynamic
+// updates, as supported by BIND, Knot, PowerDNS and (with the right
+// authentication) Active Directory. It is registered directly as "rfc2136",
+// and also used as the engine behind the "ad" preset provider.
+package rfc2136
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/miekg/dns"
+	"github.com/rancher/external-dns/providers"
+	"github.com/rancher/external-dns/utils"
+)
+
+// defaultTimeout is used when RFC2136_TIMEOUT is not set.
+const defaultTimeout = 5 * time.Second
+
+// Provider talks RFC 2136 dynamic updates (UPDATE/AXFR) to a single
+// nameserver, across one or more zones, optionally authenticated with TSIG
+// or GSS-TSIG.
+type Provider struct {
+	nameserver string
+	zones      *zoneSet
+	tcp        bool
+	timeout    time.Duration
+
+	tsigKeyName string
+	tsigAlgo    string
+	tsigSecret  map[string]string
+
+	gss *gssNegotiator
+
+	safeAdd bool
+	cache   *recordCache
+}
+
+func init() {
+	providers.RegisterProvider("rfc2136", &Provider{})
+}
+
+// NewProvider builds a Provider for nameserver/zoneName without reading any
+// environment variables, so that callers such as the "ad" preset can supply
+// their own configuration and authentication. Additional zones can be added
+// with SetZones.
+func NewProvider(nameserver, zoneName string) *Provider {
+	p := &Provider{
+		nameserver: nameserver,
+		timeout:    defaultTimeout,
+		tsigSecret: map[string]string{},
+		cache:      newRecordCache(),
+	}
+	p.zones = newZoneSet(p.soaQuery)
+	p.SetZones([]string{zoneName})
+	return p
+}
+
+// SetZones replaces the set of zones the provider considers itself
+// authoritative-adjacent for. Zones not in this list are still reachable if
+// discovered on demand (see zoneFor).
+func (p *Provider) SetZones(zones []string) {
+	p.zones.setStatic(zones)
+}
+
+// SetSafeAdd makes AddRecord carry a NameNotUsed prerequisite, so it fails
+// instead of clobbering a record that already exists under the same name.
+func (p *Provider) SetSafeAdd(safe bool) {
+	p.safeAdd = safe
+}
+
+func (p *Provider) Init(rootDomainName string) error {
+	nameserver := os.Getenv("RFC2136_NAMESERVER")
+	if len(nameserver) == 0 {
+		return fmt.Errorf("RFC2136_NAMESERVER is not set")
+	}
+	if _, _, err := net.SplitHostPort(nameserver); err != nil {
+		nameserver = net.JoinHostPort(nameserver, "53")
+	}
+
+	zoneName := os.Getenv("RFC2136_ZONE")
+	if len(zoneName) == 0 {
+		zoneName = rootDomainName
+	}
+
+	*p = *NewProvider(nameserver, zoneName)
+
+	p.tcp = os.Getenv("RFC2136_TCP") == "true"
+
+	if raw := os.Getenv("RFC2136_TIMEOUT"); len(raw) > 0 {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("Invalid RFC2136_TIMEOUT: %v", err)
+		}
+		p.timeout = time.Duration(seconds) * time.Second
+	}
+
+	if keyname := os.Getenv("RFC2136_TSIG_KEYNAME"); len(keyname) > 0 {
+		algo := os.Getenv("RFC2136_TSIG_ALGO")
+		if len(algo) == 0 {
+			algo = "hmac-sha256."
+		}
+		p.SetTsig(keyname, algo, os.Getenv("RFC2136_TSIG_SECRET"))
+	}
+
+	p.SetSafeAdd(os.Getenv("RFC2136_SAFE_ADD") == "true")
+
+	logrus.Infof("Configured %s with zone '%s' and nameserver '%s'",
+		p.GetName(), zoneName, p.nameserver)
+
+	return nil
+}
+
+func (*Provider) GetName() string {
+	return "RFC2136"
+}
+
+// SetTsig configures the provider to sign outgoing updates and transfers
+// with a static TSIG key.
+func (p *Provider) SetTsig(keyname, algo, secret string) {
+	p.tsigKeyName = dns.Fqdn(keyname)
+	p.tsigAlgo = algo
+	p.tsigSecret[p.tsigKeyName] = secret
+}
+
+// SetGSSTSIG configures the provider to authenticate with GSS-TSIG,
+// negotiating a security context via Kerberos against the nameserver.
+func (p *Provider) SetGSSTSIG(keytabPath, principal, realm string) {
+	p.gss = newGssNegotiator(keytabPath, principal, realm)
+	p.tsigAlgo = "gss-tsig."
+}
+
+// HealthCheck confirms the configured zones are reachable with a cheap SOA
+// query each, rather than transferring the full zone.
+func (p *Provider) HealthCheck() error {
+	for _, zone := range p.zones.names() {
+		if _, err := p.soaRecord(zone); err != nil {
+			return fmt.Errorf("Failed to query SOA for '%s': %v", zone, err)
+		}
+	}
+
+	return nil
+}
+
+func (p *Provider) AddRecord(record utils.DnsRecord) error {
+	zone, err := p.zones.zoneFor(record.Fqdn)
+	if err != nil {
+		return fmt.Errorf("Could not determine zone for '%s': %v", record.Fqdn, err)
+	}
+
+	logrus.Debugf("Adding RRset '%s %s' in zone '%s'", record.Fqdn, record.Type, zone)
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+
+	if p.safeAdd {
+		placeholder, err := nameRR(record.Fqdn, record.Type)
+		if err != nil {
+			return err
+		}
+		m.NameNotUsed([]dns.RR{placeholder})
+	}
+
+	rrs, err := insertRRs(record)
+	if err != nil {
+		return err
+	}
+
+	m.Insert(rrs)
+	if err := p.sendMessage(m); err != nil {
+		return wrapQueryErr(err)
+	}
+
+	p.cache.invalidate(zone)
+	return nil
+}
+
+func (p *Provider) RemoveRecord(record utils.DnsRecord) error {
+	zone, err := p.zones.zoneFor(record.Fqdn)
+	if err != nil {
+		return fmt.Errorf("Could not determine zone for '%s': %v", record.Fqdn, err)
+	}
+
+	logrus.Debugf("Removing RRset '%s %s' in zone '%s'", record.Fqdn, record.Type, zone)
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	rr, err := nameRR(record.Fqdn, record.Type)
+	if err != nil {
+		return fmt.Errorf("Could not construct RR: %v", err)
+	}
+
+	m.RemoveRRset([]dns.RR{rr})
+	if err := p.sendMessage(m); err != nil {
+		return wrapQueryErr(err)
+	}
+
+	p.cache.invalidate(zone)
+	return nil
+}
+
+// UpdateRecord replaces record's RRset in a single RFC 2136 UPDATE message,
+// guarded by prerequisites that the name and RRset still exist. Doing this
+// atomically, rather than as a separate RemoveRecord followed by AddRecord,
+// avoids an outage window if the server only applies the first half.
+func (p *Provider) UpdateRecord(record utils.DnsRecord) error {
+	zone, err := p.zones.zoneFor(record.Fqdn)
+	if err != nil {
+		return fmt.Errorf("Could not determine zone for '%s': %v", record.Fqdn, err)
+	}
+
+	logrus.Debugf("Updating RRset '%s %s' in zone '%s'", record.Fqdn, record.Type, zone)
+	placeholder, err := nameRR(record.Fqdn, record.Type)
+	if err != nil {
+		return fmt.Errorf("Could not construct RR: %v", err)
+	}
+
+	rrs, err := insertRRs(record)
+	if err != nil {
+		return err
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	m.NameUsed([]dns.RR{placeholder})
+	m.RRsetUsed([]dns.RR{placeholder})
+	m.RemoveRRset([]dns.RR{placeholder})
+	m.Insert(rrs)
+
+	if err := p.sendMessage(m); err != nil {
+		return wrapQueryErr(err)
+	}
+
+	p.cache.invalidate(zone)
+	return nil
+}
+
+// nameRR builds a throwaway RR carrying just fqdn and rrtype, suitable for
+// the prerequisite and RemoveRRset helpers, which only look at those fields.
+func nameRR(fqdn, rrtype string) (dns.RR, error) {
+	return dns.NewRR(fmt.Sprintf("%s 0 %s 0.0.0.0", fqdn, rrtype))
+}
+
+// insertRRs builds the RRs to insert for record, as used by AddRecord and
+// UpdateRecord.
+func insertRRs(record utils.DnsRecord) ([]dns.RR, error) {
+	var rrs []dns.RR
+	for _, rec := range record.Records {
+		logrus.Debugf("Adding RR: '%s %d %s %s'", record.Fqdn, record.TTL, record.Type, rec)
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d %s %s", record.Fqdn, record.TTL, record.Type, rec))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to build RR: %v", err)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, nil
+}
+
+func (p *Provider) GetRecords() ([]utils.DnsRecord, error) {
+	var list []dns.RR
+	for _, zone := range p.zones.names() {
+		zoneList, err := p.recordsForZone(zone)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, zoneList...)
+	}
+
+	var records []utils.DnsRecord
+
+OuterLoop:
+	for _, rr := range list {
+		if rr.Header().Class != dns.ClassINET {
+			continue
+		}
+
+		rrFqdn := rr.Header().Name
+		rrTTL := int(rr.Header().Ttl)
+		var rrType string
+		var rrValues []string
+		switch rr.Header().Rrtype {
+		case dns.TypeCNAME:
+			rrValues = []string{rr.(*dns.CNAME).Target}
+			rrType = "CNAME"
+		case dns.TypeA:
+			rrValues = []string{rr.(*dns.A).A.String()}
+			rrType = "A"
+		case dns.TypeAAAA:
+			rrValues = []string{rr.(*dns.AAAA).AAAA.String()}
+			rrType = "AAAA"
+		case dns.TypeTXT:
+			rrValues = rr.(*dns.TXT).Txt
+			rrType = "TXT"
+		default:
+			continue // Unhandled record type
+		}
+
+		for idx, existingRecord := range records {
+			if existingRecord.Fqdn == rrFqdn && existingRecord.Type == rrType {
+				records[idx].Records = append(records[idx].Records, rrValues...)
+				continue OuterLoop
+			}
+		}
+
+		record := utils.DnsRecord{
+			Fqdn:    rrFqdn,
+			Type:    rrType,
+			TTL:     rrTTL,
+			Records: rrValues,
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func (p *Provider) client() *dns.Client {
+	c := new(dns.Client)
+	c.SingleInflight = true
+	if p.tcp {
+		c.Net = "tcp"
+	}
+	c.Timeout = p.timeout
+	return c
+}
+
+// maxGssRenegotiations bounds how many times sendMessage will renegotiate a
+// rejected GSS-TSIG context for a single message, so a server that keeps
+// rejecting the new context (flaky KDC, clock skew, misconfigured SPN)
+// fails the call instead of retrying forever.
+const maxGssRenegotiations = 1
+
+func (p *Provider) sendMessage(msg *dns.Msg) error {
+	return p.sendMessageAttempt(msg, maxGssRenegotiations)
+}
+
+func (p *Provider) sendMessageAttempt(msg *dns.Msg, renegotiationsLeft int) error {
+	c := p.client()
+	if err := p.signMessage(c, msg); err != nil {
+		return err
+	}
+
+	resp, _, err := c.Exchange(msg, p.nameserver)
+	if err != nil {
+		return err
+	}
+
+	if resp != nil && (resp.Rcode == dns.RcodeBadSig || resp.Rcode == dns.RcodeBadKey) && p.gss != nil && renegotiationsLeft > 0 {
+		logrus.Warnf("GSS-TSIG context rejected (%s), renegotiating", dns.RcodeToString[resp.Rcode])
+		p.gss.invalidate(p.nameserver)
+		return p.sendMessageAttempt(msg, renegotiationsLeft-1)
+	}
+
+	if resp != nil && resp.Rcode != dns.RcodeSuccess {
+		return &UpdateError{Rcode: resp.Rcode}
+	}
+
+	return nil
+}
+
+func (p *Provider) list(zone string) ([]dns.RR, error) {
+	logrus.Debugf("Fetching records for '%s'", zone)
+	t := &dns.Transfer{DialTimeout: p.timeout, ReadTimeout: p.timeout}
+
+	m := new(dns.Msg)
+	m.SetAxfr(zone)
+	if err := p.signMessage(&dns.Client{}, m); err != nil {
+		return nil, err
+	}
+	t.TsigSecret = p.tsigSecret
+
+	env, err := t.In(m, p.nameserver)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch records via AXFR: %v", err)
+	}
+
+	var records []dns.RR
+	for e := range env {
+		if e.Error != nil {
+			logrus.Errorf("AXFR envelope error: %v", e.Error)
+			continue
+		}
+		records = append(records, e.RR...)
+	}
+
+	return records, nil
+}
+
+// soaQuery reports whether nameserver answers authoritatively for name,
+// i.e. it returns an SOA record for it. Used by zoneSet to discover zones.
+// A failed query (no SOA in the answer) is reported as false rather than an
+// error, so zone discovery can keep walking up the FQDN; only a transport
+// failure is propagated.
+func (p *Provider) soaQuery(name string) (bool, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeSOA)
+
+	resp, _, err := p.client().Exchange(m, p.nameserver)
+	if err != nil {
+		return false, err
+	}
+
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// signMessage attaches a TSIG record to msg and arranges for c to have the
+// matching secret, choosing between GSS-TSIG and a static TSIG key depending
+// on how the provider was configured. It is a no-op when no authentication
+// is configured.
+func (p *Provider) signMessage(c *dns.Client, msg *dns.Msg) error {
+	keyname := p.tsigKeyName
+
+	if p.gss != nil {
+		ctx, err := p.gss.context(p.nameserver)
+		if err != nil {
+			return fmt.Errorf("GSS-TSIG negotiation failed: %v", err)
+		}
+
+		keyname = ctx.keyname
+		p.tsigSecret[keyname] = ctx.secret
+	}
+
+	if len(keyname) == 0 {
+		return nil
+	}
+
+	stripTsig(msg)
+	c.TsigSecret = p.tsigSecret
+	msg.SetTsig(keyname, p.tsigAlgo, 300, time.Now().Unix())
+
+	return nil
+}
+
+// stripTsig removes any TSIG record already attached to msg, so a message
+// can be safely re-signed after a GSS-TSIG renegotiation.
+func stripTsig(msg *dns.Msg) {
+	extra := msg.Extra[:0]
+	for _, rr := range msg.Extra {
+		if rr.Header().Rrtype != dns.TypeTSIG {
+			extra = append(extra, rr)
+		}
+	}
+	msg.Extra = extra
+}
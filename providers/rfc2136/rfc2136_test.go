@@ -0,0 +1,229 @@
+package rfc2136
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rancher/external-dns/utils"
+)
+
+// fakeZone is a minimal in-memory RFC 2136 zone, served over both UDP and TCP
+// by an in-process dns.Server, standing in for BIND/Knot/PowerDNS here so
+// these tests don't depend on external infrastructure.
+type fakeZone struct {
+	mu     sync.Mutex
+	name   string
+	serial uint32
+	rrs    []dns.RR
+}
+
+func (z *fakeZone) hasName(name string) bool {
+	for _, rr := range z.rrs {
+		if rr.Header().Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (z *fakeZone) hasRRset(name string, rrtype uint16) bool {
+	for _, rr := range z.rrs {
+		if rr.Header().Name == name && rr.Header().Rrtype == rrtype {
+			return true
+		}
+	}
+	return false
+}
+
+func (z *fakeZone) soa() dns.RR {
+	rr, _ := dns.NewRR(z.name + " 3600 IN SOA ns1." + z.name + " hostmaster." + z.name + " 1 3600 600 86400 60")
+	rr.(*dns.SOA).Serial = z.serial
+	return rr
+}
+
+func (z *fakeZone) handle(w dns.ResponseWriter, r *dns.Msg) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	switch {
+	case r.Opcode == dns.OpcodeQuery && len(r.Question) == 1 && r.Question[0].Qtype == dns.TypeSOA:
+		m.Answer = []dns.RR{z.soa()}
+
+	case r.Opcode == dns.OpcodeQuery && len(r.Question) == 1 && r.Question[0].Qtype == dns.TypeAXFR:
+		m.Answer = append([]dns.RR{z.soa()}, z.rrs...)
+		m.Answer = append(m.Answer, z.soa())
+
+	case r.Opcode == dns.OpcodeUpdate:
+		if rcode := z.applyUpdate(r); rcode != dns.RcodeSuccess {
+			m.Rcode = rcode
+			break
+		}
+		z.serial++
+
+	default:
+		m.Rcode = dns.RcodeNotImplemented
+	}
+
+	w.WriteMsg(m)
+}
+
+// applyUpdate checks the prerequisite section of an RFC 2136 UPDATE message
+// against the in-memory zone, then applies its update section.
+func (z *fakeZone) applyUpdate(r *dns.Msg) int {
+	for _, pr := range r.Answer {
+		h := pr.Header()
+		switch {
+		case h.Class == dns.ClassANY && h.Rrtype == dns.TypeANY:
+			// NameUsed: the name must already exist.
+			if !z.hasName(h.Name) {
+				return dns.RcodeNameError
+			}
+		case h.Class == dns.ClassNONE && h.Rrtype == dns.TypeANY:
+			// NameNotUsed: the name must not already exist.
+			if z.hasName(h.Name) {
+				return dns.RcodeYXDomain
+			}
+		case h.Class == dns.ClassANY:
+			// RRsetUsed: an RRset of this type must already exist.
+			if !z.hasRRset(h.Name, h.Rrtype) {
+				return dns.RcodeNXRrset
+			}
+		}
+	}
+
+	for _, u := range r.Ns {
+		h := u.Header()
+		if h.Class != dns.ClassANY {
+			// Insert: the RR carries the zone's own class (e.g. IN).
+			z.rrs = append(z.rrs, u)
+			continue
+		}
+
+		// RemoveRRset: class ANY, rdlength 0, marks every RR of this
+		// name+type for deletion.
+		var kept []dns.RR
+		for _, rr := range z.rrs {
+			if rr.Header().Name == h.Name && rr.Header().Rrtype == h.Rrtype {
+				continue
+			}
+			kept = append(kept, rr)
+		}
+		z.rrs = kept
+	}
+
+	return dns.RcodeSuccess
+}
+
+// startFakeZone starts an in-process RFC 2136 server for zone name and
+// returns the address it's listening on.
+func startFakeZone(t *testing.T, name string) (addr string, zone *fakeZone, shutdown func()) {
+	zone = &fakeZone{name: dns.Fqdn(name), serial: 1}
+
+	mux := dns.NewServeMux()
+	mux.HandleFunc(zone.name, zone.handle)
+
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen on udp: %v", err)
+	}
+	tcpListener, err := net.Listen("tcp", udpConn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("failed to listen on tcp: %v", err)
+	}
+
+	// The default MsgAcceptFunc rejects dynamic updates outright; this test
+	// server needs to accept OpcodeUpdate too.
+	acceptAll := func(dh dns.Header) dns.MsgAcceptAction { return dns.MsgAccept }
+
+	udpSrv := &dns.Server{PacketConn: udpConn, Handler: mux, MsgAcceptFunc: acceptAll}
+	tcpSrv := &dns.Server{Listener: tcpListener, Handler: mux, MsgAcceptFunc: acceptAll}
+
+	go udpSrv.ActivateAndServe()
+	go tcpSrv.ActivateAndServe()
+	time.Sleep(10 * time.Millisecond)
+
+	return udpConn.LocalAddr().String(), zone, func() {
+		udpSrv.Shutdown()
+		tcpSrv.Shutdown()
+	}
+}
+
+func aRecord(fqdn, ip string) utils.DnsRecord {
+	return utils.DnsRecord{
+		Fqdn:    dns.Fqdn(fqdn),
+		Type:    "A",
+		TTL:     300,
+		Records: []string{ip},
+	}
+}
+
+func TestProviderAddGetRemoveRecord(t *testing.T) {
+	addr, _, shutdown := startFakeZone(t, "example.com.")
+	defer shutdown()
+
+	p := NewProvider(addr, "example.com.")
+
+	record := aRecord("www.example.com.", "10.0.0.1")
+	if err := p.AddRecord(record); err != nil {
+		t.Fatalf("AddRecord failed: %v", err)
+	}
+
+	records, err := p.GetRecords()
+	if err != nil {
+		t.Fatalf("GetRecords failed: %v", err)
+	}
+	if len(records) != 1 || records[0].Fqdn != record.Fqdn || records[0].Records[0] != "10.0.0.1" {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+
+	if err := p.RemoveRecord(record); err != nil {
+		t.Fatalf("RemoveRecord failed: %v", err)
+	}
+
+	records, err = p.GetRecords()
+	if err != nil {
+		t.Fatalf("GetRecords failed: %v", err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("expected no records after removal, got: %+v", records)
+	}
+}
+
+func TestProviderUpdateRecordPrerequisiteFailure(t *testing.T) {
+	addr, _, shutdown := startFakeZone(t, "example.com.")
+	defer shutdown()
+
+	p := NewProvider(addr, "example.com.")
+
+	record := aRecord("missing.example.com.", "10.0.0.2")
+	err := p.UpdateRecord(record)
+	if err == nil {
+		t.Fatal("expected UpdateRecord to fail for a name that doesn't exist")
+	}
+	if !IsPrerequisiteFailed(err) {
+		t.Fatalf("expected a prerequisite failure, got: %v", err)
+	}
+}
+
+func TestProviderSafeAddRejectsExistingName(t *testing.T) {
+	addr, _, shutdown := startFakeZone(t, "example.com.")
+	defer shutdown()
+
+	p := NewProvider(addr, "example.com.")
+	p.SetSafeAdd(true)
+
+	record := aRecord("www.example.com.", "10.0.0.1")
+	if err := p.AddRecord(record); err != nil {
+		t.Fatalf("first AddRecord failed: %v", err)
+	}
+
+	if err := p.AddRecord(record); err == nil {
+		t.Fatal("expected safe-add to reject a name that already exists")
+	}
+}
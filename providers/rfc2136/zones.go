@@ -0,0 +1,127 @@
+package rfc2136
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// zoneDiscoveryTTL bounds how long a zone found by walking up a FQDN's SOA
+// records is trusted before it is re-verified.
+const zoneDiscoveryTTL = 5 * time.Minute
+
+// zoneEntry is one zone known to the provider, either configured explicitly
+// (expires is the zero Time, meaning "never") or discovered via SOA queries.
+type zoneEntry struct {
+	expires time.Time
+}
+
+func (z zoneEntry) expired() bool {
+	return !z.expires.IsZero() && time.Now().After(z.expires)
+}
+
+// zoneSet tracks the zones a Provider is authoritative-adjacent for: either
+// the static list from AD_ZONES/RFC2136_ZONE, or zones discovered on demand
+// by walking up a record's FQDN until an SOA query succeeds.
+type zoneSet struct {
+	mu    sync.Mutex
+	zones map[string]zoneEntry
+
+	// soaQuery issues an SOA query for name against the configured
+	// nameserver; it is a method value on *Provider so tests can stub it.
+	soaQuery func(name string) (bool, error)
+}
+
+func newZoneSet(soaQuery func(name string) (bool, error)) *zoneSet {
+	return &zoneSet{
+		zones:    make(map[string]zoneEntry),
+		soaQuery: soaQuery,
+	}
+}
+
+// setStatic replaces the zone set with an explicitly configured list that
+// never expires.
+func (z *zoneSet) setStatic(zones []string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	z.zones = make(map[string]zoneEntry, len(zones))
+	for _, zone := range zones {
+		z.zones[dns.Fqdn(zone)] = zoneEntry{}
+	}
+}
+
+// names returns the currently known, non-expired zones.
+func (z *zoneSet) names() []string {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	names := make([]string, 0, len(z.zones))
+	for zone, entry := range z.zones {
+		if !entry.expired() {
+			names = append(names, zone)
+		}
+	}
+	return names
+}
+
+// zoneFor returns the longest known zone that fqdn falls under, discovering
+// (and caching) one via SOA walk-up if none of the known zones match.
+func (z *zoneSet) zoneFor(fqdn string) (string, error) {
+	fqdn = dns.Fqdn(fqdn)
+
+	if zone, ok := z.longestMatch(fqdn); ok {
+		return zone, nil
+	}
+
+	zone, err := z.discover(fqdn)
+	if err != nil {
+		return "", err
+	}
+
+	z.mu.Lock()
+	z.zones[zone] = zoneEntry{expires: time.Now().Add(zoneDiscoveryTTL)}
+	z.mu.Unlock()
+
+	return zone, nil
+}
+
+func (z *zoneSet) longestMatch(fqdn string) (string, bool) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	var best string
+	for zone, entry := range z.zones {
+		if entry.expired() {
+			continue
+		}
+		if dns.IsSubDomain(zone, fqdn) && len(zone) > len(best) {
+			best = zone
+		}
+	}
+
+	return best, best != ""
+}
+
+// discover walks up fqdn's labels, issuing an SOA query at each level until
+// one succeeds, and returns the first zone that answers authoritatively.
+func (z *zoneSet) discover(fqdn string) (string, error) {
+	labels := dns.SplitDomainName(fqdn)
+
+	for i := range labels {
+		candidate := dns.Fqdn(strings.Join(labels[i:], "."))
+
+		ok, err := z.soaQuery(candidate)
+		if err != nil {
+			return "", fmt.Errorf("SOA query for '%s' failed: %v", candidate, err)
+		}
+		if ok {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("no authoritative zone found for '%s'", fqdn)
+}
@@ -0,0 +1,64 @@
+package rfc2136
+
+import "testing"
+
+func TestZoneSetLongestMatch(t *testing.T) {
+	zs := newZoneSet(func(name string) (bool, error) {
+		t.Fatalf("discovery should not be triggered for explicitly configured zones, got query for %q", name)
+		return false, nil
+	})
+	zs.setStatic([]string{"example.com.", "svc.example.com."})
+
+	zone, ok := zs.longestMatch("app.svc.example.com.")
+	if !ok || zone != "svc.example.com." {
+		t.Fatalf("longestMatch = (%q, %v), want (\"svc.example.com.\", true)", zone, ok)
+	}
+
+	zone, ok = zs.longestMatch("other.example.com.")
+	if !ok || zone != "example.com." {
+		t.Fatalf("longestMatch = (%q, %v), want (\"example.com.\", true)", zone, ok)
+	}
+
+	if _, ok := zs.longestMatch("unrelated.org."); ok {
+		t.Fatal("longestMatch matched a zone that isn't configured")
+	}
+}
+
+func TestZoneSetDiscoversAndCaches(t *testing.T) {
+	var queried []string
+	zs := newZoneSet(func(name string) (bool, error) {
+		queried = append(queried, name)
+		return name == "example.com.", nil
+	})
+
+	zone, err := zs.zoneFor("new.example.com.")
+	if err != nil {
+		t.Fatalf("zoneFor failed: %v", err)
+	}
+	if zone != "example.com." {
+		t.Fatalf("zoneFor = %q, want \"example.com.\"", zone)
+	}
+	if len(queried) == 0 {
+		t.Fatal("expected discovery to issue at least one SOA query")
+	}
+
+	// A second lookup under the now-discovered zone should reuse the cached
+	// entry rather than walking the FQDN again.
+	queried = nil
+	if _, err := zs.zoneFor("another.new.example.com."); err != nil {
+		t.Fatalf("zoneFor failed: %v", err)
+	}
+	if len(queried) != 0 {
+		t.Fatalf("expected cached zone to be reused, got queries: %v", queried)
+	}
+}
+
+func TestZoneSetDiscoveryFailure(t *testing.T) {
+	zs := newZoneSet(func(name string) (bool, error) {
+		return false, nil
+	})
+
+	if _, err := zs.zoneFor("nowhere.example.com."); err == nil {
+		t.Fatal("expected zoneFor to fail when no zone answers authoritatively")
+	}
+}